@@ -0,0 +1,256 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bqlerr provides a typed error for a failed BQL statement, carrying
+// the stage that failed (Category), a stable Code, and -- when the
+// underlying message exposes one -- a Span pointing at the offending token,
+// so a caller such as the REPL or bw analyze can render a caret under it the
+// way `go vet` or rustc do. bql/grammar, bql/semantic, and bql/planner do not
+// themselves return a structured token position in this snapshot, so
+// ClassifyParseError recovers a best-effort Category and Span by pattern
+// matching the wrapped error's text rather than a real integration with
+// those packages; see its doc comment for the exact patterns it understands.
+package bqlerr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Category classifies which stage of running a statement produced the
+// error.
+type Category string
+
+// The stages a BQL statement goes through, any of which may fail.
+const (
+	Lexical   Category = "Lexical"
+	Syntactic Category = "Syntactic"
+	Semantic  Category = "Semantic"
+	Planning  Category = "Planning"
+	Execution Category = "Execution"
+	Storage   Category = "Storage"
+)
+
+// Span locates the offending token inside the original statement text. Line
+// and Col are 1-indexed. A stage that cannot yet identify a precise token
+// (most of bql/grammar does not, today) omits the span rather than guessing.
+type Span struct {
+	Line, Col, Length int
+}
+
+// Error is a typed BQL error carrying enough context to render a caret
+// pointing at the offending token and to be consumed by tooling as JSON.
+type Error struct {
+	// Statement is the original, unmodified statement text the error came
+	// from.
+	Statement string `json:"statement"`
+	// Span is the token the error concerns, if the originating stage could
+	// identify one.
+	Span *Span `json:"span,omitempty"`
+	// Category is the stage that produced the error.
+	Category Category `json:"category"`
+	// Code is a short, machine-readable identifier for this failure, stable
+	// across releases (e.g. "undefined_variable", "plan_failed").
+	Code string `json:"code"`
+	// Message is the human-readable description of the failure.
+	Message string `json:"message"`
+	// File is the path of the script this statement was read from, set by
+	// WithLocation when run/\source aggregate a batch into a MultiError.
+	// Empty when the statement was typed directly into the REPL.
+	File string `json:"file,omitempty"`
+	// Index is the zero-based position of the statement within File,
+	// meaningful only when File is set.
+	Index int `json:"index,omitempty"`
+	// cause is the wrapped error that triggered this one, if any. It is
+	// intentionally not part of the JSON encoding: Message already folds in
+	// every cause's text, and errors are not guaranteed to marshal cleanly.
+	cause error
+}
+
+// New returns an Error for stm produced at category, identified by code,
+// wrapping cause. cause may be nil.
+func New(stm string, category Category, code string, cause error) *Error {
+	msg := code
+	if cause != nil {
+		msg = cause.Error()
+	}
+	return &Error{Statement: stm, Category: category, Code: code, Message: msg, cause: cause}
+}
+
+// WithSpan attaches the token location to e and returns it, for chaining
+// with New.
+func (e *Error) WithSpan(line, col, length int) *Error {
+	e.Span = &Span{Line: line, Col: col, Length: length}
+	return e
+}
+
+// WithLocation attaches the source file and statement index to e and
+// returns it, for chaining with New. run and \source call this for every
+// statement failure before folding it into a MultiError, so the aggregated
+// report can say which file and which statement failed, not just why.
+func (e *Error) WithLocation(file string, idx int) *Error {
+	e.File = file
+	e.Index = idx
+	return e
+}
+
+// locationPattern matches a "line L" or "line L, column C" location that a
+// parser or semantic-validation error sometimes embeds in its message.
+var locationPattern = regexp.MustCompile(`(?i)line\s+(\d+)(?:\s*,?\s*col(?:umn)?\s+(\d+))?`)
+
+// ParseLocation scrapes a "line L" or "line L, column C" location out of msg.
+// ok is false if msg does not contain a recognizable location. This exists
+// because bql/grammar does not expose a structured token position in this
+// snapshot; once it does, callers should use that instead of scraping text.
+func ParseLocation(msg string) (line, col int, ok bool) {
+	m := locationPattern.FindStringSubmatch(msg)
+	if m == nil {
+		return 0, 0, false
+	}
+	line, _ = strconv.Atoi(m[1])
+	col = 1
+	if m[2] != "" {
+		col, _ = strconv.Atoi(m[2])
+	}
+	return line, col, true
+}
+
+// ClassifyParseError builds an *Error for a bql/grammar failure on stm.
+// bql/grammar folds lexing, parsing, and semantic validation into a single
+// Parse call and does not report which of the three failed, so this
+// keyword-matches the error text for a Category -- "semantic" for a failed
+// semantic hook, "lex"/"token" for a tokenizing failure, Syntactic otherwise
+// -- and attaches a Span via ParseLocation when the message carries one.
+// Shared by the REPL's query path and bw analyze so both stages classify a
+// parse failure the same way.
+func ClassifyParseError(stm string, err error) *Error {
+	msg := strings.ToLower(err.Error())
+	category := Syntactic
+	switch {
+	case strings.Contains(msg, "semantic"):
+		category = Semantic
+	case strings.Contains(msg, "lex") || strings.Contains(msg, "token"):
+		category = Lexical
+	}
+	e := New(stm, category, "parse_failed", err)
+	if line, col, ok := ParseLocation(err.Error()); ok {
+		e.WithSpan(line, col, 1)
+	}
+	return e
+}
+
+// Error implements the error interface, prefixing the failing statement's
+// file and position when WithLocation has set one.
+func (e *Error) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s:%d: %s error [%s]: %s", e.File, e.Index+1, e.Category, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s error [%s]: %s", e.Category, e.Code, e.Message)
+}
+
+// Cause returns the error e wraps, or nil. It follows the pkg/errors
+// Cause() convention used elsewhere for wrapped errors.
+func (e *Error) Cause() error {
+	return e.cause
+}
+
+// Unwrap returns the error e wraps, satisfying the standard errors.Unwrap
+// convention for errors.Is/errors.As.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Wrap annotates cause with msg. When cause is itself an *Error, its
+// Category, Code, and Span are preserved; any other error (an *Error nested
+// deeper in its chain included) becomes an Execution error with code
+// "wrapped". It mirrors pkg/errors.Wrap, adapted so callers that only have a
+// plain error (e.g. from storage) can still produce an *Error.
+func Wrap(cause error, msg string) *Error {
+	if cause == nil {
+		return nil
+	}
+	if inner, ok := cause.(*Error); ok {
+		wrapped := *inner
+		wrapped.Message = fmt.Sprintf("%s: %s", msg, inner.Message)
+		wrapped.cause = cause
+		return &wrapped
+	}
+	return &Error{Category: Execution, Code: "wrapped", Message: fmt.Sprintf("%s: %v", msg, cause), cause: cause}
+}
+
+// Render returns a go-vet/rustc style rendering of e: the category, code
+// and message, followed by the offending source line with a caret underline
+// when a Span is available.
+func (e *Error) Render() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[%s/%s] %s\n", e.Category, e.Code, e.Message)
+	if e.Span == nil {
+		return b.String()
+	}
+	lines := strings.Split(e.Statement, "\n")
+	if e.Span.Line < 1 || e.Span.Line > len(lines) {
+		return b.String()
+	}
+	line := lines[e.Span.Line-1]
+	fmt.Fprintln(&b, line)
+	length := e.Span.Length
+	if length < 1 {
+		length = 1
+	}
+	col := e.Span.Col
+	if col < 1 {
+		col = 1
+	}
+	fmt.Fprintf(&b, "%s%s\n", strings.Repeat(" ", col-1), strings.Repeat("^", length))
+	return b.String()
+}
+
+// JSON marshals e for --errors=json consumption by tooling.
+func (e *Error) JSON() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// MultiError aggregates the Errors produced by running a batch of
+// statements, such as every statement in a file processed by `run` or
+// `\source`, rather than stopping at the first failure.
+type MultiError struct {
+	Errors []*Error
+}
+
+// Add appends err to the batch.
+func (m *MultiError) Add(err *Error) {
+	m.Errors = append(m.Errors, err)
+}
+
+// Empty reports whether the batch has no failures.
+func (m *MultiError) Empty() bool {
+	return len(m.Errors) == 0
+}
+
+// Error implements the error interface, summarizing every failure in the
+// batch.
+func (m *MultiError) Error() string {
+	if m.Empty() {
+		return "no errors"
+	}
+	var parts []string
+	for _, e := range m.Errors {
+		parts = append(parts, e.Error())
+	}
+	return fmt.Sprintf("%d statement(s) failed:\n%s", len(m.Errors), strings.Join(parts, "\n"))
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqlerr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewAndError(t *testing.T) {
+	e := New("SELECT ?s;", Syntactic, "parse_failed", errors.New("boom"))
+	if e.Statement != "SELECT ?s;" || e.Category != Syntactic || e.Code != "parse_failed" || e.Message != "boom" {
+		t.Fatalf("New produced unexpected Error: %+v", e)
+	}
+	if got, want := e.Error(), "Syntactic error [parse_failed]: boom"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestWithLocation(t *testing.T) {
+	e := New("SELECT ?s;", Planning, "plan_failed", errors.New("no plan")).WithLocation("script.bql", 2)
+	if e.File != "script.bql" || e.Index != 2 {
+		t.Fatalf("WithLocation did not set File/Index: %+v", e)
+	}
+	if got, want := e.Error(), "script.bql:3: Planning error [plan_failed]: no plan"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderWithoutSpan(t *testing.T) {
+	e := New("SELECT ?s;", Planning, "plan_failed", errors.New("no plan"))
+	got := e.Render()
+	if !strings.Contains(got, "[Planning/plan_failed] no plan") {
+		t.Errorf("Render() = %q, missing category/code/message line", got)
+	}
+	if strings.Contains(got, "^") {
+		t.Errorf("Render() = %q, should not draw a caret without a Span", got)
+	}
+}
+
+func TestRenderWithSpan(t *testing.T) {
+	e := New("SELECT ?s\nFROM ?g;", Syntactic, "parse_failed", errors.New("bad token")).WithSpan(2, 6, 2)
+	got := e.Render()
+	lines := strings.Split(got, "\n")
+	if len(lines) < 3 {
+		t.Fatalf("Render() = %q, want at least 3 lines", got)
+	}
+	if lines[1] != "FROM ?g;" {
+		t.Errorf("Render() offending line = %q, want %q", lines[1], "FROM ?g;")
+	}
+	if want := "     ^^"; lines[2] != want {
+		t.Errorf("Render() caret line = %q, want %q", lines[2], want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	e := New("SELECT ?s;", Semantic, "undefined_variable", errors.New("?x is undefined"))
+	b, err := e.JSON()
+	if err != nil {
+		t.Fatalf("JSON() failed: %v", err)
+	}
+	s := string(b)
+	for _, want := range []string{`"category":"Semantic"`, `"code":"undefined_variable"`, `"statement":"SELECT ?s;"`} {
+		if !strings.Contains(s, want) {
+			t.Errorf("JSON() = %s, want it to contain %s", s, want)
+		}
+	}
+	if strings.Contains(s, "span") {
+		t.Errorf("JSON() = %s, span should be omitted when unset", s)
+	}
+}
+
+func TestWrapPreservesInnerError(t *testing.T) {
+	inner := New("SELECT ?s;", Planning, "plan_failed", errors.New("bad plan")).WithSpan(1, 1, 1)
+	wrapped := Wrap(inner, "while running \\source")
+	if wrapped.Category != Planning || wrapped.Code != "plan_failed" {
+		t.Errorf("Wrap() lost Category/Code: %+v", wrapped)
+	}
+	if wrapped.Span == nil || wrapped.Span.Line != 1 {
+		t.Errorf("Wrap() lost Span: %+v", wrapped.Span)
+	}
+	if !strings.Contains(wrapped.Message, "while running \\source") {
+		t.Errorf("Wrap() message = %q, want it to include the wrap reason", wrapped.Message)
+	}
+	if wrapped.Cause() != error(inner) && wrapped.Unwrap() != error(inner) {
+		t.Errorf("Wrap() should preserve inner as its cause")
+	}
+}
+
+func TestWrapPlainError(t *testing.T) {
+	plain := errors.New("file not found")
+	wrapped := Wrap(plain, "nested statement failed")
+	if wrapped.Category != Execution {
+		t.Errorf("Wrap() of a plain error category = %v, want Execution", wrapped.Category)
+	}
+	if wrapped.Cause() != plain {
+		t.Errorf("Wrap() should set cause to the plain error")
+	}
+}
+
+func TestWrapNil(t *testing.T) {
+	if Wrap(nil, "whatever") != nil {
+		t.Error("Wrap(nil, ...) should return nil")
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	m := &MultiError{}
+	if !m.Empty() {
+		t.Error("a fresh MultiError should be Empty")
+	}
+	m.Add(New("SELECT ?s;", Syntactic, "parse_failed", errors.New("boom")))
+	if m.Empty() {
+		t.Error("MultiError should not be Empty after Add")
+	}
+	if !strings.Contains(m.Error(), "1 statement(s) failed") {
+		t.Errorf("MultiError.Error() = %q, want it to report the failure count", m.Error())
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	for _, c := range []struct {
+		msg      string
+		wantLine int
+		wantCol  int
+		wantOK   bool
+	}{
+		{"syntax error at line 3, column 7", 3, 7, true},
+		{"syntax error at line 5", 5, 1, true},
+		{"no location here", 0, 0, false},
+	} {
+		line, col, ok := ParseLocation(c.msg)
+		if ok != c.wantOK || line != c.wantLine || col != c.wantCol {
+			t.Errorf("ParseLocation(%q) = %d, %d, %v, want %d, %d, %v", c.msg, line, col, ok, c.wantLine, c.wantCol, c.wantOK)
+		}
+	}
+}
+
+func TestClassifyParseError(t *testing.T) {
+	for _, c := range []struct {
+		msg  string
+		want Category
+	}{
+		{"semantic hook failed: undefined ?x", Semantic},
+		{"unexpected token ';'", Lexical},
+		{"unexpected SELECT", Syntactic},
+	} {
+		e := ClassifyParseError("SELECT ?s;", errors.New(c.msg))
+		if e.Category != c.want {
+			t.Errorf("ClassifyParseError(%q) category = %v, want %v", c.msg, e.Category, c.want)
+		}
+	}
+}
+
+func TestClassifyParseErrorWithSpan(t *testing.T) {
+	e := ClassifyParseError("SELECT ?s;", errors.New("unexpected token at line 1, column 8"))
+	if e.Span == nil || e.Span.Line != 1 || e.Span.Col != 8 {
+		t.Errorf("ClassifyParseError() span = %+v, want line 1 col 8", e.Span)
+	}
+}
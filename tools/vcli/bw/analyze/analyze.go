@@ -0,0 +1,204 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package analyze validates BQL corpora without executing them. Files (used
+// by both the REPL's `analyze` directive and the command.Command New
+// builds) feeds every statement in a set of files or globs through the
+// parser and planner in dry-run mode and reports, per statement, whether it
+// parsed, passed semantic validation, and produced a plan, stopping short of
+// Execute so a Store is never actually touched.
+package analyze
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/bqlerr"
+	"github.com/google/badwolf/bql/grammar"
+	"github.com/google/badwolf/bql/planner"
+	"github.com/google/badwolf/bql/semantic"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/tools/vcli/bw/command"
+	bio "github.com/google/badwolf/tools/vcli/bw/io"
+)
+
+// Status is the outcome of analyzing a single BQL statement.
+type Status string
+
+// The possible statuses of an analyzed statement.
+const (
+	StatusOK            Status = "OK"
+	StatusParseError    Status = "PARSE_ERROR"
+	StatusSemanticError Status = "SEMANTIC_ERROR"
+	StatusPlanError     Status = "PLAN_ERROR"
+)
+
+// Result is the outcome of analyzing a single statement.
+type Result struct {
+	File      string `json:"file"`
+	Index     int    `json:"index"`
+	Statement string `json:"statement"`
+	Status    Status `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Plan      string `json:"plan,omitempty"`
+}
+
+// Report aggregates the Results of analyzing one or more files.
+type Report struct {
+	Results []Result       `json:"results"`
+	Counts  map[Status]int `json:"counts"`
+}
+
+// newReport returns an empty Report ready to be filled in by add.
+func newReport() *Report {
+	return &Report{Counts: map[Status]int{}}
+}
+
+// add records res in the report and updates the aggregate counts.
+func (r *Report) add(res Result) {
+	r.Results = append(r.Results, res)
+	r.Counts[res.Status]++
+}
+
+// Print writes a human-readable rendering of the report to w.
+func (r *Report) Print(w io.Writer) {
+	for _, res := range r.Results {
+		fmt.Fprintf(w, "[%s] %s (statement %d)\n", res.Status, res.File, res.Index+1)
+		if res.Error != "" {
+			fmt.Fprintf(w, "\t%s\n", res.Error)
+		}
+		if res.Plan != "" {
+			fmt.Fprintf(w, "\t%s\n", res.Plan)
+		}
+	}
+	fmt.Fprintf(w, "\nOK: %d, PARSE_ERROR: %d, SEMANTIC_ERROR: %d, PLAN_ERROR: %d\n",
+		r.Counts[StatusOK], r.Counts[StatusParseError], r.Counts[StatusSemanticError], r.Counts[StatusPlanError])
+}
+
+// Files resolves patterns (plain paths or globs) and analyzes every BQL
+// statement they contain against store in dry-run mode, without ever
+// executing a plan.
+func Files(ctx context.Context, store storage.Store, chanSize int, patterns []string) (*Report, error) {
+	report := newReport()
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %v", pattern, err)
+		}
+		if len(paths) == 0 {
+			paths = []string{pattern}
+		}
+		for _, path := range paths {
+			stms, err := bio.GetStatementsFromFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file %q with error %v", path, err)
+			}
+			for idx, stm := range stms {
+				report.add(statement(ctx, store, chanSize, path, idx, stm))
+			}
+		}
+	}
+	return report, nil
+}
+
+// statement runs a single BQL statement through the parser and planner in
+// dry-run mode and classifies the outcome.
+func statement(ctx context.Context, store storage.Store, chanSize int, file string, idx int, stm string) Result {
+	res := Result{File: file, Index: idx, Statement: stm}
+
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		res.Status, res.Error = StatusParseError, fmt.Sprintf("failed to initialize a valid BQL parser: %v", err)
+		return res
+	}
+	stmt := &semantic.Statement{}
+	if err := p.Parse(grammar.NewLLk(stm, 1), stmt); err != nil {
+		// bqlerr.ClassifyParseError carries the same parse-vs-semantic
+		// heuristic the REPL's query path uses, so a statement is classified
+		// the same way whether it failed in `bw analyze` or interactively.
+		berr := bqlerr.ClassifyParseError(stm, err)
+		res.Status = StatusParseError
+		if berr.Category == bqlerr.Semantic {
+			res.Status = StatusSemanticError
+		}
+		res.Error = berr.Error()
+		return res
+	}
+
+	pln, err := planner.New(ctx, store, stmt, chanSize, nil)
+	if err != nil {
+		res.Status, res.Error = StatusPlanError, err.Error()
+		return res
+	}
+
+	res.Status = StatusOK
+	res.Plan = pln.String()
+	return res
+}
+
+// ToJSON marshals the report for CI consumption.
+func (r *Report) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// New returns the command.Command for `bw analyze`, letting a BQL corpus be
+// validated from the shell the same way the REPL's `analyze` directive does,
+// without starting a REPL session. It exits non-zero if any statement failed
+// to parse, validate, or plan, so it can gate a CI step. Registering it in
+// the `bw` binary's command table (alongside `bql`, `load`, `export`, ...)
+// is main's job, not this package's; that table lives outside this
+// snapshot, so New is ready to be wired in but is not wired in here.
+func New(driver storage.Store, chanSize int) *command.Command {
+	return &command.Command{
+		Run: func(ctx context.Context, args []string) int {
+			fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+			asJSON := fs.Bool("json", false, "print the report as JSON instead of text.")
+			fs.Parse(args)
+			patterns := fs.Args()
+			if len(patterns) == 0 {
+				fmt.Fprintln(os.Stderr, "analyze requires at least one file or glob to check")
+				return 1
+			}
+			report, err := Files(ctx, driver, chanSize, patterns)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "analyze: %v\n", err)
+				return 1
+			}
+			if *asJSON {
+				b, err := report.ToJSON()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "analyze: %v\n", err)
+					return 1
+				}
+				fmt.Println(string(b))
+			} else {
+				report.Print(os.Stdout)
+			}
+			if report.Counts[StatusOK] != len(report.Results) {
+				return 1
+			}
+			return 0
+		},
+		UsageLine: "analyze [--json] <file_or_glob>...",
+		Short:     "validates BQL files without executing them.",
+		Long: "Analyze feeds every statement in the given files or globs through the parser and planner in dry-run mode, " +
+			"reporting per statement whether it parsed, passed semantic validation, and produced a plan, without ever touching storage.",
+	}
+}
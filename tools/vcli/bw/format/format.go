@@ -0,0 +1,182 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package format provides pluggable renderers for BQL query results. A
+// Renderer knows how to turn a *table.Table into bytes on an io.Writer; the
+// REPL selects one via `set format <name>` or a per-query `\G`/`\j`/`\c`
+// suffix.
+package format
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/google/badwolf/bql/table"
+)
+
+// Renderer renders the result of a BQL query to w.
+type Renderer interface {
+	// Render writes t to w. It returns an error if the table cannot be
+	// rendered or writing to w fails.
+	Render(w io.Writer, t *table.Table) error
+}
+
+// Names of the built-in renderers, as accepted by `set format <name>` and
+// returned by New's error message when the name is unknown.
+const (
+	Table    = "table"
+	JSON     = "json"
+	NDJSON   = "ndjson"
+	CSV      = "csv"
+	TSV      = "tsv"
+	Template = "template"
+)
+
+// New returns the built-in renderer registered under name. Names follow the
+// `kind` or `kind:arg` shape; only the template kind takes an argument, the
+// Go text/template to evaluate per row.
+func New(name string) (Renderer, error) {
+	kind, arg := name, ""
+	if idx := strings.IndexByte(name, ':'); idx >= 0 {
+		kind, arg = name[:idx], name[idx+1:]
+	}
+	switch kind {
+	case Table:
+		return tableRenderer{}, nil
+	case JSON:
+		return jsonRenderer{ndjson: false}, nil
+	case NDJSON:
+		return jsonRenderer{ndjson: true}, nil
+	case CSV:
+		return delimitedRenderer{comma: ','}, nil
+	case TSV:
+		return delimitedRenderer{comma: '\t'}, nil
+	case Template:
+		if arg == "" {
+			return nil, fmt.Errorf("format %q requires a template, e.g. %q", name, "template:{{.s}} -> {{.o}}")
+		}
+		return newTemplateRenderer(arg)
+	}
+	return nil, fmt.Errorf("unknown format %q; available formats are table, json, ndjson, csv, tsv, template:<go template>", name)
+}
+
+// tableRenderer renders using table.Table's existing String representation.
+type tableRenderer struct{}
+
+func (tableRenderer) Render(w io.Writer, t *table.Table) error {
+	_, err := io.WriteString(w, t.String()+"\n")
+	return err
+}
+
+// jsonRenderer renders either a single JSON array of row objects, or, when
+// ndjson is set, one JSON object per row (streamable, one per line).
+type jsonRenderer struct {
+	ndjson bool
+}
+
+func (r jsonRenderer) Render(w io.Writer, t *table.Table) error {
+	bindings := t.Bindings()
+	enc := json.NewEncoder(w)
+	if !r.ndjson {
+		rows := []map[string]string{}
+		for i := 0; i < t.NumRows(); i++ {
+			rows = append(rows, rowToMap(t.Row(i), bindings))
+		}
+		return enc.Encode(rows)
+	}
+	for i := 0; i < t.NumRows(); i++ {
+		if err := enc.Encode(rowToMap(t.Row(i), bindings)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rowToMap(row table.Row, bindings []string) map[string]string {
+	m := make(map[string]string, len(bindings))
+	for _, b := range bindings {
+		if cell, ok := row[b]; ok && cell != nil {
+			m[b] = cell.String()
+		}
+	}
+	return m
+}
+
+// delimitedRenderer renders RFC 4180 compliant CSV or TSV, with a header row
+// taken from table.Bindings().
+type delimitedRenderer struct {
+	comma rune
+}
+
+func (r delimitedRenderer) Render(w io.Writer, t *table.Table) error {
+	bindings := t.Bindings()
+	cw := csv.NewWriter(w)
+	cw.Comma = r.comma
+	if err := cw.Write(bindings); err != nil {
+		return err
+	}
+	for i := 0; i < t.NumRows(); i++ {
+		row := t.Row(i)
+		record := make([]string, len(bindings))
+		for j, b := range bindings {
+			if cell, ok := row[b]; ok && cell != nil {
+				record[j] = cell.String()
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// templateRenderer evaluates a Go text/template per row. Binding names are
+// exposed as template fields without their leading `?` (e.g. `?s` becomes
+// `.s`), and the underlying cell is passed as-is so templates can reach the
+// typed node/predicate/literal accessors on table.Cell instead of only its
+// stringified form.
+type templateRenderer struct {
+	tmpl *template.Template
+}
+
+func newTemplateRenderer(text string) (Renderer, error) {
+	tmpl, err := template.New("row").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid row template %q: %v", text, err)
+	}
+	return templateRenderer{tmpl: tmpl}, nil
+}
+
+func (r templateRenderer) Render(w io.Writer, t *table.Table) error {
+	bindings := t.Bindings()
+	for i := 0; i < t.NumRows(); i++ {
+		row := t.Row(i)
+		data := make(map[string]*table.Cell, len(bindings))
+		for _, b := range bindings {
+			data[strings.TrimPrefix(b, "?")] = row[b]
+		}
+		if err := r.tmpl.Execute(w, data); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/badwolf/bql/table"
+)
+
+func testTable(t *testing.T) *table.Table {
+	tbl, err := table.NewTable([]string{"?s", "?o"})
+	if err != nil {
+		t.Fatalf("table.NewTable failed: %v", err)
+	}
+	s, o := "/u/alice", "/u/bob"
+	if err := tbl.AddRow(table.Row{"?s": &table.Cell{S: &s}, "?o": &table.Cell{S: &o}}); err != nil {
+		t.Fatalf("AddRow failed: %v", err)
+	}
+	return tbl
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	if _, err := New("nope"); err == nil {
+		t.Error("New(\"nope\") should have failed for an unknown format")
+	}
+}
+
+func TestNewTemplateRequiresArg(t *testing.T) {
+	if _, err := New(Template); err == nil {
+		t.Error("New(\"template\") without an argument should have failed")
+	}
+}
+
+func TestDelimitedRenderer(t *testing.T) {
+	tbl := testTable(t)
+	for _, c := range []struct {
+		name string
+		want string
+	}{
+		{CSV, "?s,?o\n/u/alice,/u/bob\n"},
+		{TSV, "?s\t?o\n/u/alice\t/u/bob\n"},
+	} {
+		r, err := New(c.name)
+		if err != nil {
+			t.Fatalf("New(%q) failed: %v", c.name, err)
+		}
+		var buf bytes.Buffer
+		if err := r.Render(&buf, tbl); err != nil {
+			t.Fatalf("Render(%q) failed: %v", c.name, err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("Render(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestJSONRenderer(t *testing.T) {
+	tbl := testTable(t)
+	r, err := New(JSON)
+	if err != nil {
+		t.Fatalf("New(json) failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := r.Render(&buf, tbl); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if got := buf.String(); !strings.Contains(got, `"?s":"/u/alice"`) || !strings.Contains(got, `"?o":"/u/bob"`) {
+		t.Errorf("Render(json) = %q, want it to contain both bindings", got)
+	}
+}
+
+func TestJSONRendererZeroRows(t *testing.T) {
+	tbl, err := table.NewTable([]string{"?s", "?o"})
+	if err != nil {
+		t.Fatalf("table.NewTable failed: %v", err)
+	}
+	r, err := New(JSON)
+	if err != nil {
+		t.Fatalf("New(json) failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := r.Render(&buf, tbl); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "[]" {
+		t.Errorf("Render(json) on a zero-row table = %q, want %q", got, "[]")
+	}
+}
+
+func TestNDJSONRenderer(t *testing.T) {
+	tbl := testTable(t)
+	r, err := New(NDJSON)
+	if err != nil {
+		t.Fatalf("New(ndjson) failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := r.Render(&buf, tbl); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if got := strings.Count(buf.String(), "\n"); got != 1 {
+		t.Errorf("Render(ndjson) produced %d lines for 1 row, want 1", got)
+	}
+}
+
+func TestTemplateRenderer(t *testing.T) {
+	tbl := testTable(t)
+	r, err := New("template:{{.s}} -> {{.o}}")
+	if err != nil {
+		t.Fatalf("New(template) failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := r.Render(&buf, tbl); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if want, got := "/u/alice -> /u/bob\n", buf.String(); got != want {
+		t.Errorf("Render(template) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+)
+
+// continuationPrompt is shown while a multi-line statement is still open,
+// waiting for the terminating semicolon.
+const continuationPrompt = "...> "
+
+// historyFile is where InteractiveReadLine persists command history across
+// sessions, relative to the user's home directory.
+const historyFile = ".bwhistory"
+
+// bqlCompleterWords lists the single-word BQL keywords and REPL verbs
+// offered by tab completion. It is intentionally flat; completion does not
+// attempt to understand BQL grammar beyond first-word matching. Verbs made
+// up of more than one word live in multiWordCompleterItems instead, since
+// chzyer/readline's PrefixCompleter matches one whitespace-delimited word of
+// input against one tree node at a time and can never match a node whose own
+// Name contains a space.
+var bqlCompleterWords = []string{
+	"SELECT", "CONSTRUCT",
+	"load", "export", "desc", "run", "analyze", "help", "quit",
+}
+
+// multiWordCompleterItems builds the completion nodes for REPL verbs and BQL
+// keywords spanning more than one word, as nested PcItem chains so each word
+// the user types is matched against its own node.
+func multiWordCompleterItems() []readline.PrefixCompleterInterface {
+	return []readline.PrefixCompleterInterface{
+		readline.PcItem("INSERT", readline.PcItem("DATA", readline.PcItem("INTO"))),
+		readline.PcItem("DELETE", readline.PcItem("DATA", readline.PcItem("FROM"))),
+		readline.PcItem("CREATE", readline.PcItem("GRAPH")),
+		readline.PcItem("DROP", readline.PcItem("GRAPH")),
+		readline.PcItem("start", readline.PcItem("tracing")),
+		readline.PcItem("stop", readline.PcItem("tracing")),
+		readline.PcItem("set", readline.PcItem("format"), readline.PcItem("var")),
+	}
+}
+
+// InteractiveReadLine returns a channel with the input to be used for the
+// REPL. Unlike SimpleReadLine, it is backed by github.com/chzyer/readline and
+// provides persistent history (stored in ~/.bwhistory), reverse-search
+// (Ctrl-R), tab completion for BQL keywords, REPL verbs, graph names, and
+// file paths for load/run/export, plus a continuation prompt for statements
+// that span multiple lines. Ctrl-C aborts the buffer currently being typed
+// without exiting the REPL; Ctrl-D (EOF) ends the session.
+func InteractiveReadLine(driver storage.Store) ReadLiner {
+	return func(done chan bool) <-chan string {
+		c := make(chan string)
+		go func() {
+			defer close(c)
+
+			rl, err := readline.NewEx(&readline.Config{
+				Prompt:          prompt,
+				HistoryFile:     historyFilePath(),
+				AutoComplete:    newBQLCompleter(driver),
+				InterruptPrompt: "^C",
+				EOFPrompt:       "quit",
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to start interactive readline, falling back to simple input: %v\n", err)
+				for l := range SimpleReadLine(done) {
+					c <- l
+				}
+				return
+			}
+			defer rl.Close()
+
+			cmd := ""
+			for {
+				if cmd == "" {
+					rl.SetPrompt(prompt)
+				} else {
+					rl.SetPrompt(continuationPrompt)
+				}
+				line, err := rl.Readline()
+				if err == readline.ErrInterrupt {
+					// Ctrl-C: abort whatever has been typed so far and start clean.
+					cmd = ""
+					continue
+				}
+				if err != nil {
+					// io.EOF or an unrecoverable terminal error; end the session.
+					break
+				}
+				cmd = strings.TrimSpace(cmd + " " + strings.TrimSpace(line))
+				if strings.HasSuffix(cmd, ";") {
+					c <- cmd
+					if <-done {
+						break
+					}
+					cmd = ""
+				}
+			}
+		}()
+		return c
+	}
+}
+
+// historyFilePath returns the path to the persistent BQL history file,
+// falling back to the current directory if the home directory cannot be
+// determined.
+func historyFilePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return historyFile
+	}
+	return filepath.Join(home, historyFile)
+}
+
+// newBQLCompleter builds the tab completer for BQL keywords, REPL verbs,
+// graph names known to driver, and file paths for load/run/export.
+func newBQLCompleter(driver storage.Store) readline.AutoCompleter {
+	items := make([]readline.PrefixCompleterInterface, 0, len(bqlCompleterWords)+len(multiWordCompleterItems()))
+	for _, w := range bqlCompleterWords {
+		switch w {
+		case "load", "run", "export":
+			items = append(items, readline.PcItem(w, readline.PcItemDynamic(completePaths)))
+		default:
+			items = append(items, readline.PcItem(w))
+		}
+	}
+	items = append(items, multiWordCompleterItems()...)
+	for _, g := range graphNames(driver) {
+		items = append(items, readline.PcItem(g))
+	}
+	return readline.NewPrefixCompleter(items...)
+}
+
+// completePaths lists every entry of the directory named by the path typed
+// so far; it does not filter them by the partial name after the last
+// separator. chzyer/readline's PcItemDynamic hands its callback the full line typed up
+// to the cursor (e.g. "load /tmp/da"), not just the path argument, so the
+// verb and any earlier arguments are stripped down to the last
+// whitespace-delimited token before resolving a directory to list.
+func completePaths(line string) []string {
+	arg := line
+	if idx := strings.LastIndexByte(line, ' '); idx >= 0 {
+		arg = line[idx+1:]
+	}
+	entries, err := os.ReadDir(filepath.Dir(arg))
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Name())
+	}
+	return out
+}
+
+// graphNames queries the driver for the known graph names so they can be
+// offered during completion. Errors are swallowed; completion simply yields
+// no graph names in that case.
+func graphNames(driver storage.Store) []string {
+	c := make(chan string)
+	var names []string
+	done := make(chan error, 1)
+	go func() {
+		done <- driver.GraphNames(context.Background(), c)
+	}()
+	for n := range c {
+		names = append(names, n)
+	}
+	<-done
+	return names
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestCompletePathsVerbAndPartialPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"data1.bql", "data2.bql"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	// chzyer/readline hands the dynamic completer the whole typed line, not
+	// just the path argument, so "load " followed by a directory prefix is
+	// what completePaths actually receives in practice.
+	got := completePaths("load " + dir + string(filepath.Separator) + "da")
+	sort.Strings(got)
+	want := []string{"data1.bql", "data2.bql"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("completePaths(%q) = %v, want %v", dir, got, want)
+	}
+}
+
+func TestCompletePathsNoPathTypedYet(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	wantEntries, err := os.ReadDir(cwd)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+
+	got := completePaths("load ")
+	if len(got) != len(wantEntries) {
+		t.Errorf("completePaths(\"load \") returned %d entries, want %d (cwd listing)", len(got), len(wantEntries))
+	}
+}
+
+func TestCompletePathsUnreadableDir(t *testing.T) {
+	if got := completePaths("load /does/not/exist/da"); got != nil {
+		t.Errorf("completePaths() for a missing directory = %v, want nil", got)
+	}
+}
+
+func TestHistoryFilePath(t *testing.T) {
+	got := historyFilePath()
+	if filepath.Base(got) != historyFile {
+		t.Errorf("historyFilePath() = %q, want it to end in %q", got, historyFile)
+	}
+}
+
+func TestMultiWordCompleterItemsCoverAllVerbs(t *testing.T) {
+	// One nested PcItem chain per multi-word verb/keyword: INSERT DATA INTO,
+	// DELETE DATA FROM, CREATE GRAPH, DROP GRAPH, start tracing, stop
+	// tracing, and set format/set var (sharing one "set" root).
+	if got, want := len(multiWordCompleterItems()), 7; got != want {
+		t.Errorf("multiWordCompleterItems() returned %d top-level nodes, want %d", got, want)
+	}
+}
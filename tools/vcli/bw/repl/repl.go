@@ -18,32 +18,54 @@ package repl
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/google/badwolf/bql/bqlerr"
 	"github.com/google/badwolf/bql/grammar"
 	"github.com/google/badwolf/bql/planner"
 	"github.com/google/badwolf/bql/semantic"
 	"github.com/google/badwolf/bql/table"
 	"github.com/google/badwolf/bql/version"
 	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/tools/vcli/bw/analyze"
 	"github.com/google/badwolf/tools/vcli/bw/command"
 	"github.com/google/badwolf/tools/vcli/bw/export"
+	"github.com/google/badwolf/tools/vcli/bw/format"
 	bio "github.com/google/badwolf/tools/vcli/bw/io"
 	"github.com/google/badwolf/tools/vcli/bw/load"
 )
 
 const prompt = "bql> "
 
+// simpleReadLine forces the REPL to fall back to SimpleReadLine instead of
+// the interactive, readline-backed input. Useful on dumb terminals that
+// cannot support the advanced editor (e.g. when piping a script is not
+// already handled by `run` or `bw bql < script.bql`).
+var simpleReadLine = flag.Bool("simple-readline", false, "use the simple line reader instead of the interactive one; for dumb terminals.")
+
+// errorsFormat controls how BQL failures are rendered. The default, "",
+// prints a go vet/rustc-style caret pointing at the offending token (when
+// known); "json" emits one bqlerr.Error JSON object per failure for tooling.
+var errorsFormat = flag.String("errors", "", "how to render BQL errors: \"\" for caret-underlined text, \"json\" for one JSON object per failure.")
+
 // New create the version command.
 func New(driver storage.Store, chanSize, bulkSize, builderSize int, rl ReadLiner, done chan bool) *command.Command {
 	return &command.Command{
 		Run: func(ctx context.Context, args []string) int {
+			if rl == nil {
+				rl = InteractiveReadLine(driver)
+				if *simpleReadLine {
+					rl = SimpleReadLine
+				}
+			}
 			REPL(driver, os.Stdin, rl, chanSize, bulkSize, builderSize, done)
 			return 0
 		},
@@ -57,10 +79,8 @@ func New(driver storage.Store, chanSize, bulkSize, builderSize int, rl ReadLiner
 type ReadLiner func(done chan bool) <-chan string
 
 // SimpleReadLine reads a line from the provided file. This does not support
-// any advanced terminal functionalities.
-//
-// TODO(xllora): Replace simple reader for function that supports advanced
-// terminal input.
+// any advanced terminal functionalities. Pass --simple-readline to force its
+// use on terminals that cannot support InteractiveReadLine.
 func SimpleReadLine(done chan bool) <-chan string {
 	c := make(chan string)
 	go func() {
@@ -86,11 +106,26 @@ func SimpleReadLine(done chan bool) <-chan string {
 	return c
 }
 
+// formatSuffixes maps the trailing `\G`, `\j`, `\c` query suffixes to the
+// built-in renderer they select for that single query.
+var formatSuffixes = map[string]string{
+	`\G`: format.Table,
+	`\j`: format.JSON,
+	`\c`: format.CSV,
+}
+
 // REPL starts a read-evaluation-print-loop to run BQL commands.
 func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize, builderSize int, done chan bool) int {
 	var tracer io.Writer
 	ctx, isTracingToFile := context.Background(), false
 
+	renderer, err := format.New(format.Table)
+	if err != nil {
+		// format.Table is always a valid built-in name.
+		panic(err)
+	}
+	st := newScriptState()
+
 	stopTracing := func() {
 		if tracer != nil {
 			if isTracingToFile {
@@ -109,15 +144,92 @@ func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize
 		fmt.Printf("\n\nThanks for all those BQL queries!\n\n")
 	}()
 
-	for l := range rl(done) {
+	// execLine runs a single semicolon-terminated REPL line: a directive
+	// (help, tracing, formatting, scripting) or, by default, a BQL query.
+	// sourceDir is the directory relative BQL-file paths resolve against; it
+	// is "" at the top level and the \source'd file's directory when called
+	// recursively for \source or run. It returns true if the REPL session
+	// should end, plus the error the statement failed with: a *bqlerr.Error
+	// for a failed query or desc, a *bqlerr.MultiError for a run or \source
+	// whose statements failed, or a plain error for a failed substitution or
+	// \source read. Other failures are only printed, and return nil.
+	var execLine func(l, sourceDir string) (bool, error)
+	execLine = func(l, sourceDir string) (bool, error) {
 		if strings.HasPrefix(l, "quit") {
-			done <- true
-			break
+			return true, nil
+		}
+		if strings.HasPrefix(l, `\if`) {
+			cond := directiveArg(l, `\if`)
+			skip := true
+			if st.active() {
+				ok, err := st.condition(cond)
+				if err != nil {
+					fmt.Printf("[ERROR] %s\n\n", err)
+					ok = false
+				}
+				skip = !ok
+			}
+			st.skipping = append(st.skipping, skip)
+			return false, nil
+		}
+		if strings.HasPrefix(l, `\endif`) {
+			if len(st.skipping) == 0 {
+				fmt.Println("[ERROR] \\endif without a matching \\if\n")
+			} else {
+				st.skipping = st.skipping[:len(st.skipping)-1]
+			}
+			return false, nil
+		}
+		if !st.active() {
+			// Statements inside a false \if branch are skipped, but the
+			// \if/\endif bookkeeping above still has to run to stay in sync.
+			return false, nil
 		}
 		if strings.HasPrefix(l, "help") {
 			printHelp()
-			done <- false
-			continue
+			return false, nil
+		}
+		if strings.HasPrefix(l, `\echo`) {
+			fmt.Println(directiveArg(l, `\echo`))
+			return false, nil
+		}
+		if strings.HasPrefix(l, `\pause`) {
+			pause()
+			return false, nil
+		}
+		if strings.HasPrefix(l, `\source`) {
+			path := directiveArg(l, `\source`)
+			if sourceDir != "" && !filepath.IsAbs(path) {
+				path = filepath.Join(sourceDir, path)
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				fmt.Printf("[ERROR] %s\n\n", err)
+				return false, nil
+			}
+			if st.sourcing[abs] {
+				fmt.Printf("[ERROR] \\source cycle detected: %q is already being sourced\n\n", path)
+				return false, nil
+			}
+			stms, err := bio.GetStatementsFromFile(path)
+			if err != nil {
+				fmt.Printf("[ERROR] failed to read %q with error %v\n\n", path, err)
+				return false, err
+			}
+			st.sourcing[abs] = true
+			defer delete(st.sourcing, abs)
+			multi := &bqlerr.MultiError{}
+			for idx, stm := range stms {
+				quit, err := execLine(stm, filepath.Dir(abs))
+				addToMulti(multi, err, path, idx)
+				if quit {
+					return true, nil
+				}
+			}
+			if multi.Empty() {
+				return false, nil
+			}
+			return false, multi
 		}
 		if strings.HasPrefix(l, "start tracing") {
 			args := strings.Split(strings.TrimSpace(l)[:len(l)-1], " ")
@@ -140,14 +252,63 @@ func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize
 			default:
 				fmt.Println("Invalid syntax\n\tstart tracing [trace_file]")
 			}
-			done <- false
-			continue
+			return false, nil
 		}
 		if strings.HasPrefix(l, "stop tracing") {
 			stopTracing()
 			fmt.Println("Tracing is off.")
-			done <- false
-			continue
+			return false, nil
+		}
+		if strings.HasPrefix(l, "set format") {
+			name := directiveArg(l, "set format")
+			if name == "" {
+				fmt.Println("Invalid syntax\n\tset format <table|json|ndjson|csv|tsv|template:<go template>>")
+			} else if r, err := format.New(name); err != nil {
+				fmt.Printf("[ERROR] %s\n\n", err)
+			} else {
+				renderer = r
+				fmt.Printf("[OK] format set to %q\n\n", name)
+			}
+			return false, nil
+		}
+		if strings.HasPrefix(l, "set var") {
+			name, val, ok := splitAssignment(directiveArg(l, "set var"))
+			if !ok {
+				fmt.Println("Invalid syntax\n\tset var ?name = <value>;")
+			} else {
+				st.vars[name] = val
+				fmt.Printf("[OK] %s = %s\n\n", name, val)
+			}
+			return false, nil
+		}
+		if strings.HasPrefix(l, "let") {
+			name, query, ok := splitAssignment(directiveArg(l, "let"))
+			if !ok {
+				fmt.Println("Invalid syntax\n\tlet ?name = <BQL>;")
+				return false, nil
+			}
+			query, err := st.substitute(query + ";")
+			if err != nil {
+				fmt.Printf("[ERROR] %s\n\n", err)
+				return false, err
+			}
+			stm, queryRenderer := stripFormatSuffix(query, renderer)
+			now := time.Now()
+			tbl, err := runBQL(ctx, stm, driver, chanSize, tracer)
+			if err != nil {
+				printQueryErr(err)
+				fmt.Println("Time spent: ", time.Now().Sub(now))
+				fmt.Println()
+				return false, err
+			}
+			st.lets[name] = tbl
+			if len(tbl.Bindings()) > 0 {
+				if err := queryRenderer.Render(os.Stdout, tbl); err != nil {
+					fmt.Printf("[ERROR] failed to render results: %s\n", err)
+				}
+			}
+			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
+			return false, nil
 		}
 		if strings.HasPrefix(l, "export") {
 			now := time.Now()
@@ -155,8 +316,7 @@ func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize
 			usage := "Wrong syntax\n\n\tload <graph_names_separated_by_commas> <file_path>\n"
 			export.Eval(ctx, usage, args, driver, bulkSize)
 			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
-			done <- false
-			continue
+			return false, nil
 		}
 		if strings.HasPrefix(l, "load") {
 			now := time.Now()
@@ -164,85 +324,209 @@ func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize
 			usage := "Wrong syntax\n\n\tload <file_path> <graph_names_separated_by_commas>\n"
 			load.Eval(ctx, usage, args, driver, bulkSize, builderSize)
 			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
-			done <- false
-			continue
+			return false, nil
+		}
+		if strings.HasPrefix(l, "analyze") {
+			now := time.Now()
+			args := strings.Fields(strings.TrimSpace(l[:len(l)-1]))
+			usage := "Wrong syntax\n\n\tanalyze [--json] <file_or_glob>...\n"
+			if len(args) < 2 {
+				fmt.Println(usage)
+			} else {
+				asJSON, patterns := false, args[1:]
+				if patterns[0] == "--json" {
+					asJSON, patterns = true, patterns[1:]
+				}
+				report, err := analyze.Files(ctx, driver, chanSize, patterns)
+				if err != nil {
+					fmt.Printf("[ERROR] %s\n\n", err)
+				} else if asJSON {
+					b, err := report.ToJSON()
+					if err != nil {
+						fmt.Printf("[ERROR] %s\n\n", err)
+					} else {
+						fmt.Println(string(b))
+					}
+				} else {
+					report.Print(os.Stdout)
+				}
+			}
+			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
+			return false, nil
 		}
 		if strings.HasPrefix(l, "desc") {
 			pln, err := planBQL(ctx, l[4:], driver, chanSize, nil)
 			if err != nil {
-				fmt.Printf("[ERROR] %s\n\n", err)
+				printQueryErr(err)
 			} else {
 				fmt.Println(pln.String())
 				fmt.Println("[OK]")
 			}
-			done <- false
-			continue
+			return false, err
 		}
 		if strings.HasPrefix(l, "run") {
 			now := time.Now()
-			path, cmds, err := runBQLFromFile(ctx, driver, chanSize, strings.TrimSpace(l[:len(l)-1]), tracer)
+			path := directiveArg(l, "run")
+			if sourceDir != "" && !filepath.IsAbs(path) {
+				path = filepath.Join(sourceDir, path)
+			}
+			abs, err := filepath.Abs(path)
 			if err != nil {
 				fmt.Printf("[ERROR] %s\n\n", err)
+				fmt.Println("Time spent: ", time.Now().Sub(now))
+				return false, nil
+			}
+			if st.sourcing[abs] {
+				fmt.Printf("[ERROR] run cycle detected: %q is already being run\n\n", path)
+				fmt.Println("Time spent: ", time.Now().Sub(now))
+				return false, nil
+			}
+			stms, err := bio.GetStatementsFromFile(path)
+			if err != nil {
+				fmt.Printf("[ERROR] failed to read file %q with error %v\n\n", path, err)
+				fmt.Println("Time spent: ", time.Now().Sub(now))
+				return false, nil
+			}
+			st.sourcing[abs] = true
+			quit, multi := false, &bqlerr.MultiError{}
+			for idx, stm := range stms {
+				fmt.Printf("Processing statement (%d/%d)\n", idx+1, len(stms))
+				var serr error
+				quit, serr = execLine(stm, filepath.Dir(abs))
+				addToMulti(multi, serr, path, idx)
+				if quit {
+					break
+				}
+			}
+			delete(st.sourcing, abs)
+			if multi.Empty() {
+				fmt.Printf("Loaded %q and run %d BQL commands successfully\n\n", path, len(stms))
 			} else {
-				fmt.Printf("Loaded %q and run %d BQL commands successfully\n\n", path, cmds)
+				fmt.Printf("%s\n\n", multi)
 			}
 			fmt.Println("Time spent: ", time.Now().Sub(now))
-			done <- false
-			continue
+			if multi.Empty() {
+				return quit, nil
+			}
+			return quit, multi
 		}
 
+		stm, err := st.substitute(l)
+		if err != nil {
+			fmt.Printf("[ERROR] %s\n\n", err)
+			return false, err
+		}
+		stm, queryRenderer := stripFormatSuffix(stm, renderer)
+
 		now := time.Now()
-		table, err := runBQL(ctx, l, driver, chanSize, tracer)
+		table, err := runBQL(ctx, stm, driver, chanSize, tracer)
 		if err != nil {
-			fmt.Printf("[ERROR] %s\n", err)
+			printQueryErr(err)
 			fmt.Println("Time spent: ", time.Now().Sub(now))
 			fmt.Println()
-		} else {
-			if len(table.Bindings()) > 0 {
-				fmt.Println(table.String())
+			return false, err
+		}
+		if len(table.Bindings()) > 0 {
+			if err := queryRenderer.Render(os.Stdout, table); err != nil {
+				fmt.Printf("[ERROR] failed to render results: %s\n", err)
 			}
-			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
+		}
+		fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
+		return false, nil
+	}
+
+	for l := range rl(done) {
+		quit, _ := execLine(l, "")
+		if quit {
+			done <- true
+			break
 		}
 		done <- false
 	}
 	return 0
 }
 
+// addToMulti folds err into multi: a *bqlerr.Error is tagged with the file
+// and statement index it came from and appended, a *bqlerr.MultiError has
+// its Errors merged in as-is (each already carries the location its own
+// run/\source loop gave it), nil is ignored, and anything else (e.g. a plain
+// error from a failed \source read) is wrapped first so it is not silently
+// dropped from the batch.
+func addToMulti(multi *bqlerr.MultiError, err error, file string, idx int) {
+	switch e := err.(type) {
+	case nil:
+	case *bqlerr.Error:
+		multi.Add(e.WithLocation(file, idx))
+	case *bqlerr.MultiError:
+		multi.Errors = append(multi.Errors, e.Errors...)
+	default:
+		multi.Add(bqlerr.Wrap(e, "nested statement failed").WithLocation(file, idx))
+	}
+}
+
+// directiveArg strips the terminating ';' and the directive name from l,
+// returning the remaining argument text.
+func directiveArg(l, name string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l[:len(l)-1]), name))
+}
+
+// splitAssignment splits a `?name = value` argument, as used by `set var`
+// and `let`, into its variable name and value. ok is false if arg is not of
+// that shape or the name does not start with '?'.
+func splitAssignment(arg string) (name, value string, ok bool) {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	name = strings.TrimSpace(parts[0])
+	if !strings.HasPrefix(name, "?") {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(parts[1]), true
+}
+
+// stripFormatSuffix checks the statement for a trailing `\G`, `\j`, or `\c`
+// marker immediately before the terminating semicolon. If found, it returns
+// the statement with the marker removed and the one-off renderer it selects;
+// otherwise it returns the statement unchanged along with def.
+func stripFormatSuffix(stm string, def format.Renderer) (string, format.Renderer) {
+	trimmed := strings.TrimSpace(stm)
+	for suffix, name := range formatSuffixes {
+		marker := suffix + ";"
+		if !strings.HasSuffix(trimmed, marker) {
+			continue
+		}
+		r, err := format.New(name)
+		if err != nil {
+			break
+		}
+		return trimmed[:len(trimmed)-len(marker)] + ";", r
+	}
+	return stm, def
+}
+
 // printHelp prints help for the console commands.
 func printHelp() {
 	fmt.Println("help                                                  - prints help for the bw console.")
 	fmt.Println("export <graph_names_separated_by_commas> <file_path>  - dumps triples from graphs into a file path.")
+	fmt.Println("analyze [--json] <file_or_glob>...                    - validates BQL files/globs without executing them.")
 	fmt.Println("desc <BQL>                                            - prints the execution plan for a BQL statement.")
 	fmt.Println("load <file_path> <graph_names_separated_by_commas>    - load triples into the specified graphs.")
 	fmt.Println("run <file_with_bql_statements>                        - runs all the BQL statements in the file.")
+	fmt.Println("set format <table|json|ndjson|csv|tsv|template:...>   - sets the renderer used to print query results.")
+	fmt.Println("<BQL> \\G|\\j|\\c;                                      - renders just this query as table, json, or csv.")
+	fmt.Println("set var ?name = <value>                               - binds ?name so $?name substitutes in later statements.")
+	fmt.Println("let ?name = <BQL>                                     - runs a query and captures its result as ?name.")
+	fmt.Println("\\source <path>                                        - inlines and runs another script.")
+	fmt.Println("\\if ?name ... \\endif                                  - runs the block only if ?name is bound/non-empty.")
+	fmt.Println("\\echo <text>                                          - prints text.")
+	fmt.Println("\\pause                                                - waits for enter before continuing.")
 	fmt.Println("start tracing [trace_file]                            - starts tracing queries.")
 	fmt.Println("stop tracing                                          - stops tracing queries.")
 	fmt.Println("quit                                                  - quits the console.")
 	fmt.Println()
 }
 
-// runBQLFromFile loads all the statements in the file and runs them.
-func runBQLFromFile(ctx context.Context, driver storage.Store, chanSize int, line string, w io.Writer) (string, int, error) {
-	ss := strings.Split(strings.TrimSpace(line), " ")
-	if len(ss) != 2 {
-		return "", 0, fmt.Errorf("wrong syntax: run <file_with_bql_statements>")
-	}
-	path := ss[1]
-	lines, err := bio.GetStatementsFromFile(path)
-	if err != nil {
-		return "", 0, fmt.Errorf("failed to read file %q with error %v on\n", path, err)
-	}
-	for idx, stm := range lines {
-		fmt.Printf("Processing statement (%d/%d)\n", idx+1, len(lines))
-		_, err := runBQL(ctx, stm, driver, chanSize, w)
-		if err != nil {
-			return "", 0, fmt.Errorf("%v on\n%s\n", err, stm)
-		}
-	}
-	fmt.Println()
-	return path, len(lines), nil
-}
-
 // runBQL attempts to execute the provided query against the given store.
 func runBQL(ctx context.Context, bql string, s storage.Store, chanSize int, w io.Writer) (*table.Table, error) {
 	pln, err := planBQL(ctx, bql, s, chanSize, w)
@@ -251,7 +535,15 @@ func runBQL(ctx context.Context, bql string, s storage.Store, chanSize int, w io
 	}
 	res, err := pln.Execute(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("planner.Execute: failed to execute query plan with error %v", err)
+		// Execute ultimately calls down into the storage driver; when the
+		// error text identifies itself as coming from there, file it under
+		// Storage rather than the more generic Execution.
+		category := bqlerr.Execution
+		msg := strings.ToLower(err.Error())
+		if strings.Contains(msg, "storage") || strings.Contains(msg, "driver") {
+			category = bqlerr.Storage
+		}
+		return nil, bqlerr.New(bql, category, "execute_failed", err)
 	}
 	return res, nil
 }
@@ -260,15 +552,36 @@ func runBQL(ctx context.Context, bql string, s storage.Store, chanSize int, w io
 func planBQL(ctx context.Context, bql string, s storage.Store, chanSize int, w io.Writer) (planner.Executor, error) {
 	p, err := grammar.NewParser(grammar.SemanticBQL())
 	if err != nil {
-		return nil, fmt.Errorf("failed to initilize a valid BQL parser")
+		return nil, bqlerr.New(bql, bqlerr.Syntactic, "parser_init_failed", err)
 	}
 	stm := &semantic.Statement{}
 	if err := p.Parse(grammar.NewLLk(bql, 1), stm); err != nil {
-		return nil, fmt.Errorf("failed to parse BQL statement with error %v", err)
+		return nil, bqlerr.ClassifyParseError(bql, err)
 	}
 	pln, err := planner.New(ctx, s, stm, chanSize, w)
 	if err != nil {
-		return nil, fmt.Errorf("should have not failed to create a plan using memory.DefaultStorage for statement %v with error %v", stm, err)
+		return nil, bqlerr.New(bql, bqlerr.Planning, "plan_failed", err)
 	}
 	return pln, nil
 }
+
+// printQueryErr renders a BQL failure according to --errors: as JSON, one
+// object per failure, or as caret-underlined text pointing at the offending
+// token when the error carries a bqlerr.Span.
+func printQueryErr(err error) {
+	be, ok := err.(*bqlerr.Error)
+	if !ok {
+		fmt.Printf("[ERROR] %s\n", err)
+		return
+	}
+	if *errorsFormat == "json" {
+		b, jerr := be.JSON()
+		if jerr != nil {
+			fmt.Printf("[ERROR] %s\n", err)
+			return
+		}
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Print(be.Render())
+}
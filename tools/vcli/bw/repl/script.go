@@ -0,0 +1,114 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/badwolf/bql/table"
+)
+
+// varRef matches a `$?name` substitution token. Substitution only ever
+// happens pre-parse, on the raw statement text, so it has no notion of BQL
+// quoting beyond refusing to substitute an undefined variable.
+var varRef = regexp.MustCompile(`\$(\?[A-Za-z_][A-Za-z0-9_]*)`)
+
+// scriptState carries the mutable state needed to run `set var`, `let`,
+// `\source`, `\if`/`\endif`, `\echo`, and `\pause` across the statements of a
+// REPL session or a `run`/`\source`d script. The zero value is not usable;
+// use newScriptState.
+type scriptState struct {
+	// vars holds the raw BQL substitution value bound by `set var ?x = ...;`.
+	vars map[string]string
+	// lets holds the result set captured by `let ?t = SELECT ...;`, used to
+	// evaluate `\if ?t;` as a result-set-non-empty check.
+	lets map[string]*table.Table
+	// sourcing tracks the absolute paths of `\source`d files currently being
+	// read, so a file that (directly or transitively) sources itself is
+	// rejected instead of recursing forever.
+	sourcing map[string]bool
+	// skipping is a stack of the `\if` blocks currently open; a true entry
+	// means statements in that block (and any nested block) are not run.
+	skipping []bool
+}
+
+// newScriptState returns an empty scriptState ready to run a session.
+func newScriptState() *scriptState {
+	return &scriptState{
+		vars:     map[string]string{},
+		lets:     map[string]*table.Table{},
+		sourcing: map[string]bool{},
+	}
+}
+
+// active reports whether a statement reached under the current `\if` nesting
+// should actually run.
+func (s *scriptState) active() bool {
+	for _, skip := range s.skipping {
+		if skip {
+			return false
+		}
+	}
+	return true
+}
+
+// substitute replaces every `$?name` token in stm with the value bound to
+// ?name via `set var`. It fails fast if a referenced variable is undefined,
+// so a typo cannot silently inject a partial BQL token into the statement.
+func (s *scriptState) substitute(stm string) (string, error) {
+	var err error
+	out := varRef.ReplaceAllStringFunc(stm, func(tok string) string {
+		name := tok[1:] // drop the leading '$', keep the '?'.
+		val, ok := s.vars[name]
+		if !ok {
+			err = fmt.Errorf("undefined script variable %q referenced as %q", name, tok)
+			return tok
+		}
+		return val
+	})
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// condition evaluates the argument of `\if <cond>;`: a result set captured by
+// `let` is true if it has at least one row, a variable bound by `set var` is
+// true, and any other `?name` is false. A cond that does not start with '?'
+// and names neither is an error.
+func (s *scriptState) condition(cond string) (bool, error) {
+	cond = strings.TrimSpace(cond)
+	if t, ok := s.lets[cond]; ok {
+		return t.NumRows() > 0, nil
+	}
+	if _, ok := s.vars[cond]; ok {
+		return true, nil
+	}
+	if strings.HasPrefix(cond, "?") {
+		return false, nil
+	}
+	return false, fmt.Errorf("\\if: %q is neither a script variable nor a captured result set", cond)
+}
+
+// pause blocks until the user presses Enter. It powers `\pause;` in
+// interactive demos.
+func pause() {
+	fmt.Print("-- press enter to continue --")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+}
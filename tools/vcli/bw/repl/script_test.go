@@ -0,0 +1,100 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repl
+
+import (
+	"testing"
+
+	"github.com/google/badwolf/bql/table"
+)
+
+func TestScriptStateSubstitute(t *testing.T) {
+	s := newScriptState()
+	s.vars["?g"] = "/u/graph"
+	got, err := s.substitute("CREATE GRAPH $?g;")
+	if err != nil {
+		t.Fatalf("substitute failed: %v", err)
+	}
+	if want := "CREATE GRAPH /u/graph;"; got != want {
+		t.Errorf("substitute() = %q, want %q", got, want)
+	}
+}
+
+func TestScriptStateSubstituteUndefined(t *testing.T) {
+	s := newScriptState()
+	if _, err := s.substitute("CREATE GRAPH $?missing;"); err == nil {
+		t.Error("substitute() with an undefined variable should have failed")
+	}
+}
+
+func TestScriptStateActive(t *testing.T) {
+	s := newScriptState()
+	if !s.active() {
+		t.Error("active() with no open \\if blocks should be true")
+	}
+	s.skipping = []bool{false, true}
+	if s.active() {
+		t.Error("active() with a skipping block on the stack should be false")
+	}
+	s.skipping = []bool{false, false}
+	if !s.active() {
+		t.Error("active() with no skipping block on the stack should be true")
+	}
+}
+
+func TestScriptStateConditionVar(t *testing.T) {
+	s := newScriptState()
+	s.vars["?g"] = "/u/graph"
+	ok, err := s.condition("?g")
+	if err != nil {
+		t.Fatalf("condition failed: %v", err)
+	}
+	if !ok {
+		t.Error("condition(?g) with ?g bound should be true")
+	}
+}
+
+func TestScriptStateConditionLet(t *testing.T) {
+	s := newScriptState()
+
+	empty, err := table.NewTable([]string{"?s"})
+	if err != nil {
+		t.Fatalf("table.NewTable failed: %v", err)
+	}
+	s.lets["?empty"] = empty
+	if ok, err := s.condition("?empty"); err != nil || ok {
+		t.Errorf("condition(?empty) = %v, %v, want false, nil", ok, err)
+	}
+
+	nonEmpty, err := table.NewTable([]string{"?s"})
+	if err != nil {
+		t.Fatalf("table.NewTable failed: %v", err)
+	}
+	v := "/u/alice"
+	if err := nonEmpty.AddRow(table.Row{"?s": &table.Cell{S: &v}}); err != nil {
+		t.Fatalf("AddRow failed: %v", err)
+	}
+	s.lets["?t"] = nonEmpty
+	if ok, err := s.condition("?t"); err != nil || !ok {
+		t.Errorf("condition(?t) = %v, %v, want true, nil", ok, err)
+	}
+}
+
+func TestScriptStateConditionUndefined(t *testing.T) {
+	s := newScriptState()
+	if _, err := s.condition("undefined"); err == nil {
+		t.Error("condition() on a name without '?' that is neither a var nor a let should fail")
+	}
+}